@@ -0,0 +1,66 @@
+package donut
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec marshals and unmarshals work payloads for CreateWork, GetWork,
+// and UpdateWork. Config.Codec selects which one a cluster uses; it
+// defaults to JSONCodec.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes with encoding/json. It's donut's original, default
+// wire format and works with plain map[string]interface{} payloads.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes with encoding/gob, which round-trips Go types (ints,
+// times, byte slices) that JSON can't represent faithfully.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec encodes with protocol buffers via google.golang.org/protobuf.
+// Values passed to Marshal and Unmarshal must implement proto.Message.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("donut: ProtoCodec requires a proto.Message, got %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("donut: ProtoCodec requires a proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,56 @@
+package donut
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestGobCodecRoundTrip(t *testing.T) {
+	type payload struct {
+		Name  string
+		Count int
+	}
+	var codec GobCodec
+
+	data, err := codec.Marshal(payload{Name: "foo", Count: 3})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out payload
+	if err := codec.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Name != "foo" || out.Count != 3 {
+		t.Fatalf("expected {foo 3}, got %+v", out)
+	}
+}
+
+func TestProtoCodecRoundTrip(t *testing.T) {
+	var codec ProtoCodec
+
+	data, err := codec.Marshal(wrapperspb.String("foo"))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	out := &wrapperspb.StringValue{}
+	if err := codec.Unmarshal(data, out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.GetValue() != "foo" {
+		t.Fatalf("expected value=foo, got %q", out.GetValue())
+	}
+}
+
+func TestProtoCodecRejectsNonProtoMessage(t *testing.T) {
+	var codec ProtoCodec
+
+	if _, err := codec.Marshal(map[string]interface{}{"n": 1}); err == nil {
+		t.Fatal("expected Marshal to reject a non-proto.Message value")
+	}
+	if err := codec.Unmarshal(nil, &struct{}{}); err == nil {
+		t.Fatal("expected Unmarshal to reject a non-proto.Message value")
+	}
+}
@@ -0,0 +1,21 @@
+package donut
+
+// Config holds the per-cluster settings the rest of donut reads work,
+// lock, and watch paths from.
+type Config struct {
+	// WorkPath is the znode path, relative to a cluster's root, under
+	// which CreateWork/CompleteWork/GetWork/UpdateWork store work items.
+	WorkPath string
+
+	// Codec encodes and decodes work payloads. Defaults to JSONCodec if
+	// left nil.
+	Codec Codec
+}
+
+// codec returns config.Codec, or JSONCodec{} if none was set.
+func (config *Config) codec() Codec {
+	if config.Codec == nil {
+		return JSONCodec{}
+	}
+	return config.Codec
+}
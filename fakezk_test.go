@@ -0,0 +1,174 @@
+package donut
+
+import (
+	"path"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZK is an in-memory ZKClient for tests: just enough of ZooKeeper's
+// semantics (sequential/ephemeral Create, versioned Set, one-shot
+// watches) to exercise Lock, the CAS work helpers, watchZKChildren, and
+// WatchManager without a real ensemble.
+type fakeZK struct {
+	mu sync.Mutex
+
+	nodes map[string]*fakeZKNode
+
+	// watches keyed by the exact path they're armed on, for ExistsW/GetW.
+	pathWatches map[string][]chan zk.Event
+	// watches keyed by the parent path whose children they're armed on.
+	childWatches map[string][]chan zk.Event
+
+	seq int
+}
+
+type fakeZKNode struct {
+	data      []byte
+	version   int32
+	ephemeral bool
+}
+
+var _ ZKClient = (*fakeZK)(nil)
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{
+		nodes:        make(map[string]*fakeZKNode),
+		pathWatches:  make(map[string][]chan zk.Event),
+		childWatches: make(map[string][]chan zk.Event),
+	}
+}
+
+func (f *fakeZK) firePath(p string) {
+	for _, ch := range f.pathWatches[p] {
+		ch <- zk.Event{Path: p}
+	}
+	delete(f.pathWatches, p)
+}
+
+func (f *fakeZK) fireChildren(parent string) {
+	for _, ch := range f.childWatches[parent] {
+		ch <- zk.Event{Path: parent}
+	}
+	delete(f.childWatches, parent)
+}
+
+func (f *fakeZK) Children(p string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.childrenLocked(p), nil
+}
+
+func (f *fakeZK) childrenLocked(p string) []string {
+	var children []string
+	for k := range f.nodes {
+		if k != p && path.Dir(k) == p {
+			children = append(children, path.Base(k))
+		}
+	}
+	return children
+}
+
+func (f *fakeZK) ChildrenW(p string) ([]string, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan zk.Event, 1)
+	f.childWatches[p] = append(f.childWatches[p], ch)
+	return f.childrenLocked(p), ch, nil
+}
+
+func (f *fakeZK) Create(p string, data []byte, flags int32) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parent := path.Dir(p)
+	if parent != "/" {
+		if _, ok := f.nodes[parent]; !ok {
+			return "", zk.ErrNoNode
+		}
+	}
+
+	created := p
+	if flags&zk.FlagSequence != 0 {
+		f.seq++
+		created = p + zeroPad(f.seq)
+	}
+	if _, ok := f.nodes[created]; ok {
+		return "", zk.ErrNodeExists
+	}
+	f.nodes[created] = &fakeZKNode{data: data, ephemeral: flags&zk.FlagEphemeral != 0}
+	f.fireChildren(path.Dir(created))
+	return created, nil
+}
+
+func (f *fakeZK) Get(p string) ([]byte, int32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.nodes[p]
+	if !ok {
+		return nil, 0, zk.ErrNoNode
+	}
+	return n.data, n.version, nil
+}
+
+func (f *fakeZK) GetW(p string) ([]byte, int32, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.nodes[p]
+	if !ok {
+		return nil, 0, nil, zk.ErrNoNode
+	}
+	ch := make(chan zk.Event, 1)
+	f.pathWatches[p] = append(f.pathWatches[p], ch)
+	return n.data, n.version, ch, nil
+}
+
+func (f *fakeZK) Set(p string, data []byte, version int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.nodes[p]
+	if !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return zk.ErrBadVersion
+	}
+	n.data = data
+	n.version++
+	f.firePath(p)
+	return nil
+}
+
+func (f *fakeZK) Delete(p string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.nodes[p]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.nodes, p)
+	f.firePath(p)
+	f.fireChildren(path.Dir(p))
+	return nil
+}
+
+func (f *fakeZK) ExistsW(p string) (bool, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.nodes[p]
+	ch := make(chan zk.Event, 1)
+	f.pathWatches[p] = append(f.pathWatches[p], ch)
+	return exists, ch, nil
+}
+
+func (f *fakeZK) Close() {}
+
+func zeroPad(n int) string {
+	const digits = "0123456789"
+	b := make([]byte, 10)
+	for i := 9; i >= 0; i-- {
+		b[i] = digits[n%10]
+		n /= 10
+	}
+	return string(b)
+}
@@ -0,0 +1,143 @@
+package donut
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Lock is a cluster-wide mutual exclusion lock backed by ZooKeeper
+// ephemeral sequential znodes, following the standard ZK lock recipe:
+// each contender creates a "lock-" sequence node under path, and holds
+// the lock once its node has the lowest sequence number among siblings.
+type Lock struct {
+	zkc  ZKClient
+	path string
+	node string // full path of the sequence node created by Lock/TryLock
+}
+
+// NewLock returns a Lock that serializes access to path. Lock creates
+// path itself, as a persistent znode, if it doesn't already exist, and
+// creates ephemeral sequential children under it.
+func NewLock(zkc ZKClient, path string) *Lock {
+	return &Lock{zkc: zkc, path: path}
+}
+
+// Lock blocks until the caller holds the lock.
+func (l *Lock) Lock() error {
+	return l.TryLock(context.Background())
+}
+
+// TryLock blocks until the caller holds the lock or ctx is done. If ctx
+// is canceled before the lock is acquired, the sequence node created for
+// this attempt is removed.
+func (l *Lock) TryLock(ctx context.Context) (err error) {
+	if err = ensurePath(l.zkc, l.path); err != nil {
+		return err
+	}
+
+	node, err := l.zkc.Create(path.Join(l.path, "lock-"), nil, zk.FlagEphemeral|zk.FlagSequence)
+	if err != nil {
+		return err
+	}
+	l.node = node
+
+	for {
+		held, watchPath, err := l.checkLowest()
+		if err != nil {
+			l.cleanup()
+			return err
+		}
+		if held {
+			return nil
+		}
+
+		exists, watch, err := l.zkc.ExistsW(watchPath)
+		if err != nil {
+			l.cleanup()
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		select {
+		case <-watch:
+		case <-ctx.Done():
+			l.cleanup()
+			return ctx.Err()
+		}
+	}
+}
+
+// ensurePath creates every znode along p that doesn't already exist, as
+// persistent znodes. ZooKeeper's Create never materializes missing
+// ancestors on its own, so callers that Create a child under an
+// arbitrary path (like Lock, for a work item's lock path) need to walk
+// and create the chain themselves first.
+func ensurePath(zkc ZKClient, p string) error {
+	if p == "" || p == "/" {
+		return nil
+	}
+	if err := ensurePath(zkc, path.Dir(p)); err != nil {
+		return err
+	}
+	if _, err := zkc.Create(p, nil, 0); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+	return nil
+}
+
+// checkLowest reports whether this lock's node currently has the lowest
+// sequence number among its siblings. If it does not, it returns the
+// path of the next-lower sibling, which the caller should watch.
+func (l *Lock) checkLowest() (held bool, watchPath string, err error) {
+	children, err := l.zkc.Children(l.path)
+	if err != nil {
+		return false, "", err
+	}
+	sort.Strings(children)
+
+	self := path.Base(l.node)
+	for i, c := range children {
+		if c != self {
+			continue
+		}
+		if i == 0 {
+			return true, "", nil
+		}
+		return false, path.Join(l.path, children[i-1]), nil
+	}
+	return false, "", fmt.Errorf("donut: lock node %s not found under %s", self, l.path)
+}
+
+// Unlock releases the lock by deleting the sequence node created by Lock
+// or TryLock.
+func (l *Lock) Unlock() error {
+	return l.cleanup()
+}
+
+func (l *Lock) cleanup() error {
+	if l.node == "" {
+		return nil
+	}
+	err := l.zkc.Delete(l.node)
+	l.node = ""
+	return err
+}
+
+// WithWorkLock runs fn while holding a Lock scoped to workId within
+// clusterName, serializing it against any other WithWorkLock call on the
+// same work item. It's intended to wrap mutations like CreateWork and
+// CompleteWork to avoid lost-update races on WorkPath.
+func WithWorkLock(clusterName string, zkc ZKClient, config *Config, workId string, fn func() error) error {
+	l := NewLock(zkc, path.Join("/", clusterName, config.WorkPath, "locks", workId))
+	if err := l.Lock(); err != nil {
+		return err
+	}
+	defer l.Unlock()
+	return fn()
+}
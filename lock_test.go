@@ -0,0 +1,114 @@
+package donut
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockAcquireRelease(t *testing.T) {
+	f := newFakeZK()
+	l := NewLock(f, "/locks/foo")
+
+	if err := l.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if children, _ := f.Children("/locks/foo"); len(children) != 1 {
+		t.Fatalf("expected exactly one sequence node, got %v", children)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if children, _ := f.Children("/locks/foo"); len(children) != 0 {
+		t.Fatalf("expected Unlock to remove the sequence node, got %v", children)
+	}
+}
+
+func TestLockSecondContenderWaitsForFirst(t *testing.T) {
+	f := newFakeZK()
+
+	first := NewLock(f, "/locks/foo")
+	if err := first.Lock(); err != nil {
+		t.Fatalf("first Lock: %v", err)
+	}
+
+	second := NewLock(f, "/locks/foo")
+	acquired := make(chan error, 1)
+	go func() { acquired <- second.Lock() }()
+
+	select {
+	case <-acquired:
+		t.Fatal("second contender acquired the lock while the first still held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("first Unlock: %v", err)
+	}
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("second Lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second contender never acquired the lock after the first released it")
+	}
+}
+
+func TestTryLockCanceledContextRemovesSequenceNode(t *testing.T) {
+	f := newFakeZK()
+
+	first := NewLock(f, "/locks/foo")
+	if err := first.Lock(); err != nil {
+		t.Fatalf("first Lock: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	second := NewLock(f, "/locks/foo")
+	tried := make(chan error, 1)
+	go func() { tried <- second.TryLock(ctx) }()
+
+	select {
+	case <-tried:
+		t.Fatal("second contender returned while the first still held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case err := <-tried:
+		if err != ctx.Err() {
+			t.Fatalf("expected TryLock to return ctx.Err(), got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TryLock never returned after ctx was canceled")
+	}
+
+	children, _ := f.Children("/locks/foo")
+	if len(children) != 1 {
+		t.Fatalf("expected only the first contender's sequence node to remain, got %v", children)
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("first Unlock: %v", err)
+	}
+}
+
+func TestWithWorkLockCreatesMissingParent(t *testing.T) {
+	f := newFakeZK()
+	config := &Config{WorkPath: "work"}
+
+	var ran bool
+	err := WithWorkLock("cluster", f, config, "work-1", func() error {
+		ran = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithWorkLock: %v", err)
+	}
+	if !ran {
+		t.Fatal("fn was never called")
+	}
+}
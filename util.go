@@ -1,12 +1,12 @@
 package donut
 
 import (
-	"encoding/json"
 	"fmt"
-	"launchpad.net/gozk/zookeeper"
 	"log"
 	"path"
 	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 // A locked map
@@ -70,6 +70,18 @@ func (m *SafeMap) RangeUnlock() {
 	m.lk.RUnlock()
 }
 
+// Take an extended write lock over the map, for callers that need to
+// mutate it directly (e.g. diffing and updating in one critical section)
+func (m *SafeMap) WriteLock() map[string]interface{} {
+	m.lk.Lock()
+	return m._map
+}
+
+// Release extended write lock
+func (m *SafeMap) WriteUnlock() {
+	m.lk.Unlock()
+}
+
 // Copy the map into a normal map
 func (m *SafeMap) GetCopy() map[string]interface{} {
 	m.lk.RLock()
@@ -116,85 +128,107 @@ func (m *SafeMap) Keys() (keys []string) {
 	return
 }
 
-// Watch the children at path until a byte is sent on the returned channel
-// Uses the SafeMap more like a set, so you'll have to use Contains() for entries
-func watchZKChildren(zk *zookeeper.Conn, path string, children *SafeMap, onChange func(*SafeMap)) (chan byte, error) {
-	initial, _, watch, err := zk.ChildrenW(path)
+// Watch the children at path until a byte is sent on the returned kill
+// channel. Uses the SafeMap more like a set, so you'll have to use
+// Contains() for entries. onChange is called with the current SafeMap and
+// the sets of children added and removed since the last callback, diffed
+// under the SafeMap's write lock so handlers never see a torn update.
+// Errors from re-establishing the watch are delivered on the returned
+// error channel rather than just logged; the watcher stops after the
+// first one.
+func watchZKChildren(zkc ZKClient, path string, children *SafeMap, onChange func(current *SafeMap, added, removed []string)) (chan byte, <-chan error, error) {
+	initial, watch, err := zkc.ChildrenW(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	m := children.RangeLock()
+	m := children.WriteLock()
 	for _, node := range initial {
-		m[node] = nil
+		m[node] = struct{}{}
 	}
-	children.RangeUnlock()
+	children.WriteUnlock()
+
 	kill := make(chan byte, 1)
+	errs := make(chan error, 1)
 	go func() {
 		defer close(kill)
-		var nodes []string
-		var err error
+		defer close(errs)
 		for {
 			select {
 			case <-kill:
 				// close(watch)
 				return
 			case event := <-watch:
-				if !event.Ok() {
+				if event.Err != nil {
 					continue
 				}
 				// close(watch)
-				nodes, _, watch, err = zk.ChildrenW(path)
+				nodes, w, err := zkc.ChildrenW(path)
 				if err != nil {
-					log.Printf("Error in watchZkChildren: %v", err)
-					// XXX I should really provide some way for the client to find out about this error...
+					errs <- err
 					return
 				}
-				m := children.RangeLock()
-				// mark all dead
-				for k := range m {
-					m[k] = 0
-				}
+				watch = w
+
+				current := make(map[string]bool, len(nodes))
 				for _, node := range nodes {
-					m[node] = 1
+					current[node] = true
 				}
-				for k, v := range m {
-					if v.(int) == 0 {
+
+				m := children.WriteLock()
+				var added, removed []string
+				for k := range m {
+					if !current[k] {
+						removed = append(removed, k)
 						delete(m, k)
 					}
 				}
-				children.RangeUnlock()
-				onChange(children)
+				for _, node := range nodes {
+					if _, ok := m[node]; !ok {
+						added = append(added, node)
+						m[node] = struct{}{}
+					}
+				}
+				children.WriteUnlock()
+
+				if len(added) > 0 || len(removed) > 0 {
+					onChange(children, added, removed)
+				}
 			}
 		}
 	}()
 	log.Printf("watcher setup on %s", path)
-	return kill, nil
+	return kill, errs, nil
 }
 
-func serializeCreate(zk *zookeeper.Conn, path string, data map[string]interface{}) (err error) {
+func serializeCreate(zkc ZKClient, codec Codec, p string, data interface{}) (err error) {
+	if err = ensurePath(zkc, path.Dir(p)); err != nil {
+		return
+	}
 	var e []byte
-	if e, err = json.Marshal(data); err != nil {
+	if e, err = codec.Marshal(data); err != nil {
 		return
 	}
-	_, err = zk.Create(path, string(e), 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err = zkc.Create(p, e, 0)
 	return
 }
 
-func getDeserialize(zk *zookeeper.Conn, path string) (data map[string]interface{}, err error) {
-	var e string
-	e, _, err = zk.Get(path)
+func getDeserialize(zkc ZKClient, codec Codec, path string, out interface{}) (err error) {
+	var e []byte
+	e, _, err = zkc.Get(path)
 	if err != nil {
 		log.Printf("error on get in getDeserialize for %s: %v", path, err)
 		return
 	}
-	err = json.Unmarshal([]byte(e), &data)
+	err = codec.Unmarshal(e, out)
 	return
 }
 
-// Create work in a cluster
-func CreateWork(clusterName string, zk *zookeeper.Conn, config *Config, workId string, data map[string]interface{}) (err error) {
+// Create work in a cluster. data is encoded with config.Codec (JSONCodec
+// by default), so it may be a map[string]interface{} or any typed struct
+// the codec supports.
+func CreateWork(clusterName string, zkc ZKClient, config *Config, workId string, data interface{}) (err error) {
 	p := path.Join("/", clusterName, config.WorkPath, workId)
-	if err = serializeCreate(zk, p, data); err != nil {
+	if err = serializeCreate(zkc, config.codec(), p, data); err != nil {
 		log.Printf("Failed to create work %s (%s): %v", workId, p, err)
 	} else {
 		log.Printf("Created work %s", p)
@@ -203,8 +237,96 @@ func CreateWork(clusterName string, zk *zookeeper.Conn, config *Config, workId s
 }
 
 // Remove work from a cluster
-func CompleteWork(clusterName string, zk *zookeeper.Conn, config *Config, workId string) {
+func CompleteWork(clusterName string, zkc ZKClient, config *Config, workId string) {
 	p := path.Join("/", clusterName, config.WorkPath, workId)
-	zk.Delete(p, -1)
+	zkc.Delete(p)
 	log.Printf("Deleted work %s (%s)", workId, p)
 }
+
+// ErrBadVersion is returned by UpdateWork when a concurrent writer has
+// already advanced the work item's version past the one the caller read.
+type ErrBadVersion struct {
+	WorkId string
+}
+
+func (e *ErrBadVersion) Error() string {
+	return fmt.Sprintf("donut: version mismatch updating work %s", e.WorkId)
+}
+
+// GetWork fetches a work item's data into out (which should be a pointer,
+// e.g. *map[string]interface{} or a pointer to a typed struct matching
+// config.Codec) along with the znode version needed to safely update it
+// with UpdateWork.
+func GetWork(clusterName string, zkc ZKClient, config *Config, workId string, out interface{}) (version int32, err error) {
+	p := path.Join("/", clusterName, config.WorkPath, workId)
+	var e []byte
+	if e, version, err = zkc.Get(p); err != nil {
+		log.Printf("error on get in GetWork for %s: %v", workId, err)
+		return
+	}
+	err = config.codec().Unmarshal(e, out)
+	return
+}
+
+// UpdateWork atomically replaces a work item's data, failing with
+// *ErrBadVersion if version does not match the version currently stored
+// (i.e. another writer updated it after the caller's GetWork). data is
+// encoded with config.Codec.
+func UpdateWork(clusterName string, zkc ZKClient, config *Config, workId string, data interface{}, version int32) (err error) {
+	p := path.Join("/", clusterName, config.WorkPath, workId)
+	var e []byte
+	if e, err = config.codec().Marshal(data); err != nil {
+		return
+	}
+	if err = zkc.Set(p, e, version); err != nil {
+		if err == zk.ErrBadVersion {
+			return &ErrBadVersion{WorkId: workId}
+		}
+		log.Printf("Failed to update work %s (%s): %v", workId, p, err)
+		return
+	}
+	log.Printf("Updated work %s", p)
+	return
+}
+
+// WatchWork invokes onChange whenever workId's data changes, passing the
+// newly read data, decoded with config.Codec, and its version. newOut is
+// called once per event to allocate the value GetWork decodes into (e.g.
+// func() interface{} { return &map[string]interface{}{} } for JSONCodec,
+// or func() interface{} { return new(pb.Work) } for ProtoCodec), since a
+// single shared instance can't be reused safely across codecs. WatchWork
+// returns a channel that stops the watch when a byte is sent on it.
+func WatchWork(clusterName string, zkc ZKClient, config *Config, workId string, newOut func() interface{}, onChange func(data interface{}, version int32)) (chan byte, error) {
+	p := path.Join("/", clusterName, config.WorkPath, workId)
+	_, _, watch, err := zkc.GetW(p)
+	if err != nil {
+		return nil, err
+	}
+	kill := make(chan byte, 1)
+	go func() {
+		defer close(kill)
+		for {
+			select {
+			case <-kill:
+				return
+			case event := <-watch:
+				if event.Err != nil {
+					continue
+				}
+				data := newOut()
+				version, werr := GetWork(clusterName, zkc, config, workId, data)
+				if werr != nil {
+					log.Printf("Error in WatchWork for %s: %v", workId, werr)
+					return
+				}
+				_, _, watch, err = zkc.GetW(p)
+				if err != nil {
+					log.Printf("Error rearming watch in WatchWork for %s: %v", workId, err)
+					return
+				}
+				onChange(data, version)
+			}
+		}
+	}()
+	return kill, nil
+}
@@ -0,0 +1,160 @@
+package donut
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateGetUpdateWorkRoundTrip(t *testing.T) {
+	f := newFakeZK()
+	config := &Config{WorkPath: "work", Codec: JSONCodec{}}
+
+	if err := CreateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(1)}); err != nil {
+		t.Fatalf("CreateWork: %v", err)
+	}
+
+	var data map[string]interface{}
+	version, err := GetWork("cluster", f, config, "work-1", &data)
+	if err != nil {
+		t.Fatalf("GetWork: %v", err)
+	}
+	if data["n"] != float64(1) {
+		t.Fatalf("expected n=1, got %v", data["n"])
+	}
+
+	data["n"] = float64(2)
+	if err := UpdateWork("cluster", f, config, "work-1", data, version); err != nil {
+		t.Fatalf("UpdateWork: %v", err)
+	}
+
+	var reread map[string]interface{}
+	if _, err := GetWork("cluster", f, config, "work-1", &reread); err != nil {
+		t.Fatalf("GetWork after update: %v", err)
+	}
+	if reread["n"] != float64(2) {
+		t.Fatalf("expected n=2 after update, got %v", reread["n"])
+	}
+}
+
+func TestUpdateWorkStaleVersionReturnsErrBadVersion(t *testing.T) {
+	f := newFakeZK()
+	config := &Config{WorkPath: "work", Codec: JSONCodec{}}
+
+	if err := CreateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(1)}); err != nil {
+		t.Fatalf("CreateWork: %v", err)
+	}
+
+	var data map[string]interface{}
+	version, err := GetWork("cluster", f, config, "work-1", &data)
+	if err != nil {
+		t.Fatalf("GetWork: %v", err)
+	}
+
+	// A concurrent writer advances the version out from under us.
+	if err := UpdateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(2)}, version); err != nil {
+		t.Fatalf("concurrent UpdateWork: %v", err)
+	}
+
+	err = UpdateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(3)}, version)
+	if _, ok := err.(*ErrBadVersion); !ok {
+		t.Fatalf("expected *ErrBadVersion for a stale version, got %v", err)
+	}
+}
+
+func TestWatchZKChildrenReportsAddedAndRemoved(t *testing.T) {
+	f := newFakeZK()
+	if _, err := f.Create("/group", nil, 0); err != nil {
+		t.Fatalf("Create /group: %v", err)
+	}
+	if _, err := f.Create("/group/a", nil, 0); err != nil {
+		t.Fatalf("Create /group/a: %v", err)
+	}
+
+	type change struct{ added, removed []string }
+	changes := make(chan change, 4)
+
+	children := NewSafeMap(nil)
+	kill, errs, err := watchZKChildren(f, "/group", children, func(_ *SafeMap, added, removed []string) {
+		changes <- change{added, removed}
+	})
+	if err != nil {
+		t.Fatalf("watchZKChildren: %v", err)
+	}
+	defer func() { kill <- 0 }()
+	go func() {
+		for err := range errs {
+			t.Errorf("unexpected watch error: %v", err)
+		}
+	}()
+
+	if _, err := f.Create("/group/b", nil, 0); err != nil {
+		t.Fatalf("Create /group/b: %v", err)
+	}
+	select {
+	case c := <-changes:
+		if len(c.added) != 1 || c.added[0] != "b" || len(c.removed) != 0 {
+			t.Fatalf("expected added=[b] removed=[], got added=%v removed=%v", c.added, c.removed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for added notification")
+	}
+
+	if err := f.Delete("/group/a"); err != nil {
+		t.Fatalf("Delete /group/a: %v", err)
+	}
+	select {
+	case c := <-changes:
+		if len(c.removed) != 1 || c.removed[0] != "a" || len(c.added) != 0 {
+			t.Fatalf("expected added=[] removed=[a], got added=%v removed=%v", c.added, c.removed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for removed notification")
+	}
+
+	if !children.Contains("b") || children.Contains("a") {
+		t.Fatalf("SafeMap out of sync with watched children: %v", children.Keys())
+	}
+}
+
+func TestWatchWorkReportsChanges(t *testing.T) {
+	f := newFakeZK()
+	config := &Config{WorkPath: "work", Codec: JSONCodec{}}
+
+	if err := CreateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(1)}); err != nil {
+		t.Fatalf("CreateWork: %v", err)
+	}
+
+	type event struct {
+		data    interface{}
+		version int32
+	}
+	events := make(chan event, 4)
+	newOut := func() interface{} { return &map[string]interface{}{} }
+	kill, err := WatchWork("cluster", f, config, "work-1", newOut, func(data interface{}, version int32) {
+		events <- event{data, version}
+	})
+	if err != nil {
+		t.Fatalf("WatchWork: %v", err)
+	}
+	defer func() { kill <- 0 }()
+
+	if err := UpdateWork("cluster", f, config, "work-1", map[string]interface{}{"n": float64(2)}, 0); err != nil {
+		t.Fatalf("UpdateWork: %v", err)
+	}
+
+	select {
+	case e := <-events:
+		data, ok := e.data.(*map[string]interface{})
+		if !ok {
+			t.Fatalf("expected *map[string]interface{}, got %T", e.data)
+		}
+		if (*data)["n"] != float64(2) {
+			t.Fatalf("expected n=2, got %v", (*data)["n"])
+		}
+		if e.version != 1 {
+			t.Fatalf("expected version=1, got %d", e.version)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for change notification")
+	}
+}
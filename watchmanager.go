@@ -0,0 +1,144 @@
+package donut
+
+import (
+	"log"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// WatchManager keeps ZooKeeper child watches alive across session
+// expirations and disconnects, which a bare watchZKChildren call does
+// not survive: once a session expires every outstanding watch needs to
+// be re-armed against the new session. It listens on the ZK client's
+// session event stream and, on zk.StateExpired, re-installs every watch,
+// firing a synthetic resync event on each so handlers can reconcile.
+//
+// It does not recreate ephemeral nodes the process owned; a session
+// expiration means they're gone, and for sequential nodes like Lock's
+// there's no path to recreate them at anyway (a new Create gets a new
+// sequence number). Owners of ephemeral nodes need to notice the
+// expiration themselves and decide whether to re-acquire.
+type WatchManager struct {
+	zkc ZKClient
+
+	watches *SafeMap // path -> *childWatch
+
+	kill chan byte
+}
+
+type childWatch struct {
+	handler func(current *SafeMap, added, removed []string)
+	cancel  chan byte
+}
+
+// NewWatchManager starts a WatchManager that watches sessionEvents, the
+// session event stream for zkc, for session expirations.
+func NewWatchManager(zkc ZKClient, sessionEvents <-chan zk.Event) *WatchManager {
+	wm := &WatchManager{
+		zkc:     zkc,
+		watches: NewSafeMap(nil),
+		kill:    make(chan byte, 1),
+	}
+	go wm.run(sessionEvents)
+	return wm
+}
+
+// Subscription lets a caller cancel a watch registered with WatchChildren.
+type Subscription struct {
+	path string
+	wm   *WatchManager
+}
+
+// Cancel stops the watch and removes its bookkeeping from the manager.
+func (s *Subscription) Cancel() {
+	s.wm.cancelWatch(s.path)
+}
+
+// WatchChildren registers handler to be called whenever the children at
+// path change. After a session expiration and resync, handler is also
+// called once with added set to every current child and removed nil, so
+// callers can reconcile from a clean slate rather than missing events
+// that occurred while the session was down.
+func (wm *WatchManager) WatchChildren(path string, handler func(current *SafeMap, added, removed []string)) (*Subscription, error) {
+	current := NewSafeMap(nil)
+	cancel, errs, err := watchZKChildren(wm.zkc, path, current, handler)
+	if err != nil {
+		return nil, err
+	}
+	go logWatchErrors(path, errs)
+
+	wm.watches.Put(path, &childWatch{handler: handler, cancel: cancel})
+	return &Subscription{path: path, wm: wm}, nil
+}
+
+func logWatchErrors(path string, errs <-chan error) {
+	for err := range errs {
+		log.Printf("WatchManager: watch on %s failed, will resync on next reconnect: %v", path, err)
+	}
+}
+
+func (wm *WatchManager) cancelWatch(path string) {
+	v := wm.watches.Delete(path)
+	if w, ok := v.(*childWatch); ok {
+		select {
+		case <-w.cancel:
+			// Closed: the watcher already exited after an error.
+		default:
+			w.cancel <- 0
+		}
+	}
+}
+
+// Close stops watching the session event stream and cancels every
+// registered watch.
+func (wm *WatchManager) Close() {
+	wm.kill <- 0
+	for _, p := range wm.watches.Keys() {
+		wm.cancelWatch(p)
+	}
+}
+
+func (wm *WatchManager) run(sessionEvents <-chan zk.Event) {
+	for {
+		select {
+		case <-wm.kill:
+			return
+		case event := <-sessionEvents:
+			if event.State != zk.StateExpired {
+				continue
+			}
+			wm.resync()
+		}
+	}
+}
+
+// resync re-installs every registered watch after a session expiration,
+// firing a synthetic resync event on each handler with the
+// post-reconnect children.
+func (wm *WatchManager) resync() {
+	log.Printf("WatchManager: session expired, resyncing")
+
+	for _, p := range wm.watches.Keys() {
+		w, ok := wm.watches.Get(p).(*childWatch)
+		if !ok {
+			continue
+		}
+		select {
+		case <-w.cancel:
+			// Closed: the watcher already exited after an error.
+		default:
+			w.cancel <- 0
+		}
+
+		fresh := NewSafeMap(nil)
+		cancel, errs, err := watchZKChildren(wm.zkc, p, fresh, w.handler)
+		if err != nil {
+			log.Printf("WatchManager: failed to re-install watch on %s: %v", p, err)
+			continue
+		}
+		go logWatchErrors(p, errs)
+
+		wm.watches.Put(p, &childWatch{handler: w.handler, cancel: cancel})
+		w.handler(fresh, fresh.Keys(), nil)
+	}
+}
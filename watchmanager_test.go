@@ -0,0 +1,75 @@
+package donut
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestWatchManagerResyncFiresSyntheticEvent(t *testing.T) {
+	f := newFakeZK()
+	if _, err := f.Create("/group", nil, 0); err != nil {
+		t.Fatalf("Create /group: %v", err)
+	}
+	if _, err := f.Create("/group/a", nil, 0); err != nil {
+		t.Fatalf("Create /group/a: %v", err)
+	}
+
+	type change struct{ added, removed []string }
+	changes := make(chan change, 4)
+
+	sessionEvents := make(chan zk.Event, 1)
+	wm := NewWatchManager(f, sessionEvents)
+	defer wm.Close()
+
+	if _, err := wm.WatchChildren("/group", func(_ *SafeMap, added, removed []string) {
+		changes <- change{added, removed}
+	}); err != nil {
+		t.Fatalf("WatchChildren: %v", err)
+	}
+
+	// A real child-add notification before any expiration.
+	if _, err := f.Create("/group/b", nil, 0); err != nil {
+		t.Fatalf("Create /group/b: %v", err)
+	}
+	select {
+	case c := <-changes:
+		if len(c.added) != 1 || c.added[0] != "b" {
+			t.Fatalf("expected added=[b], got %v", c.added)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for added notification")
+	}
+
+	// Now simulate a session expiration. The manager should re-install the
+	// watch and fire a synthetic resync with every current child.
+	if _, err := f.Create("/group/c", nil, 0); err != nil {
+		t.Fatalf("Create /group/c: %v", err)
+	}
+	// Drain the ordinary change notification from the create above so it
+	// doesn't get mistaken for the resync below.
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for notification of /group/c before expiring the session")
+	}
+
+	sessionEvents <- zk.Event{State: zk.StateExpired}
+
+	select {
+	case c := <-changes:
+		if len(c.removed) != 0 {
+			t.Fatalf("expected a resync event with no removals, got removed=%v", c.removed)
+		}
+		got := map[string]bool{}
+		for _, a := range c.added {
+			got[a] = true
+		}
+		if !got["a"] || !got["b"] || !got["c"] {
+			t.Fatalf("expected resync to report every current child, got %v", c.added)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for resync notification")
+	}
+}
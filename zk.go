@@ -0,0 +1,117 @@
+package donut
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZKClient is the slice of ZooKeeper functionality donut depends on. It
+// exists so the package isn't pinned to a single driver and so tests can
+// inject a fake client instead of talking to a real ensemble.
+type ZKClient interface {
+	// Children lists the children of path.
+	Children(path string) (children []string, err error)
+
+	// ChildrenW lists the children of path and sets a watch that fires the
+	// next time they change.
+	ChildrenW(path string) (children []string, watch <-chan zk.Event, err error)
+
+	// Create makes a znode at path with the given data and flags (e.g.
+	// zk.FlagEphemeral, zk.FlagSequence), using a world-readable/writable
+	// ACL. It returns the path actually created, which differs from path
+	// when zk.FlagSequence is set.
+	Create(path string, data []byte, flags int32) (string, error)
+
+	// Get returns the data stored at path along with its current version.
+	Get(path string) (data []byte, version int32, err error)
+
+	// GetW returns the data stored at path along with its current version,
+	// and sets a watch that fires the next time the data changes.
+	GetW(path string) (data []byte, version int32, watch <-chan zk.Event, err error)
+
+	// Set overwrites the data at path if its current version matches
+	// version, or returns an error if it does not.
+	Set(path string, data []byte, version int32) error
+
+	// Delete removes path unconditionally.
+	Delete(path string) error
+
+	// ExistsW reports whether path exists and sets a watch that fires the
+	// next time its existence or data changes.
+	ExistsW(path string) (exists bool, watch <-chan zk.Event, err error)
+
+	// Close releases the underlying connection.
+	Close()
+}
+
+// zkClient adapts a *zk.Conn from github.com/samuel/go-zookeeper/zk to
+// ZKClient.
+type zkClient struct {
+	conn *zk.Conn
+}
+
+// NewZKClient wraps conn, a connection from github.com/samuel/go-zookeeper/zk,
+// for use by donut.
+func NewZKClient(conn *zk.Conn) ZKClient {
+	return &zkClient{conn: conn}
+}
+
+// Connect dials servers and returns a ZKClient along with the connection's
+// session event stream, which WatchManager needs to notice session
+// expirations.
+func Connect(servers []string, sessionTimeout time.Duration) (ZKClient, <-chan zk.Event, error) {
+	conn, events, err := zk.Connect(servers, sessionTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewZKClient(conn), events, nil
+}
+
+func (c *zkClient) Children(path string) ([]string, error) {
+	children, _, err := c.conn.Children(path)
+	return children, err
+}
+
+func (c *zkClient) ChildrenW(path string) ([]string, <-chan zk.Event, error) {
+	children, _, watch, err := c.conn.ChildrenW(path)
+	return children, watch, err
+}
+
+func (c *zkClient) Create(path string, data []byte, flags int32) (string, error) {
+	return c.conn.Create(path, data, flags, zk.WorldACL(zk.PermAll))
+}
+
+func (c *zkClient) Get(path string) ([]byte, int32, error) {
+	data, stat, err := c.conn.Get(path)
+	if stat == nil {
+		return data, 0, err
+	}
+	return data, stat.Version, err
+}
+
+func (c *zkClient) GetW(path string) ([]byte, int32, <-chan zk.Event, error) {
+	data, stat, watch, err := c.conn.GetW(path)
+	if stat == nil {
+		return data, 0, watch, err
+	}
+	return data, stat.Version, watch, err
+}
+
+func (c *zkClient) Set(path string, data []byte, version int32) error {
+	_, err := c.conn.Set(path, data, version)
+	return err
+}
+
+func (c *zkClient) Delete(path string) error {
+	return c.conn.Delete(path, -1)
+}
+
+func (c *zkClient) ExistsW(path string) (bool, <-chan zk.Event, error) {
+	exists, _, watch, err := c.conn.ExistsW(path)
+	return exists, watch, err
+}
+
+func (c *zkClient) Close() {
+	c.conn.Close()
+}